@@ -15,21 +15,114 @@ package common
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
+	"errors"
+	"net/http"
 
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	"k8s.io/api/networking/v1beta1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/apimachinery/pkg/util/strategicpatch"
 	"k8s.io/client-go/kubernetes"
-	coreclient "k8s.io/client-go/kubernetes/typed/core/v1"
+	networkingv1client "k8s.io/client-go/kubernetes/typed/networking/v1"
 	client "k8s.io/client-go/kubernetes/typed/networking/v1beta1"
-	"k8s.io/klog"
 	"k8s.io/kubernetes/pkg/util/slice"
+
+	"k8s.io/ingress-gce/pkg/utils/common/finalizer"
+)
+
+// ingressFinalizerManager, ingressV1FinalizerManager and
+// serviceFinalizerManager share the generic FinalizerManager implementation
+// across resource types; only the patch schema object, the module-owned
+// finalizer keys and the ResourceAdapter passed to Add/Remove differ per type.
+//
+// TODO(ingress-gce): register FinalizerManager adapters for the module's
+// CRDs (NEG, BackendConfig, FrontendConfig, ServiceAttachment) too. They're
+// intentionally not done here: their Go types aren't present in this tree,
+// so adding adapters for them would be unreviewable guesswork rather than a
+// port of existing code. Scoped out as follow-up for whoever owns those
+// controllers, not silently dropped.
+var (
+	ingressFinalizerManager   = finalizer.NewFinalizerManager(v1beta1.Ingress{}, []string{FinalizerKey, FinalizerKeyV2})
+	ingressV1FinalizerManager = finalizer.NewFinalizerManager(networkingv1.Ingress{}, []string{FinalizerKey, FinalizerKeyV2})
+	serviceFinalizerManager   = finalizer.NewFinalizerManager(corev1.Service{}, []string{LegacyILBFinalizer, ILBFinalizerV2})
 )
 
+// RegisterIngressAfterFinalizerRemoved wires hook to run once the last
+// module-owned finalizer is cleared from an Ingress being deleted, whether
+// that happens through EnsureDeleteFinalizer or is only observed afterwards
+// via CheckIngressAfterFinalizerRemoved/CheckIngressAfterFinalizerRemovedV1,
+// e.g. to reconcile GCE-side resource release even if a user strips the
+// finalizer out-of-band and the informer never sees a subsequent delete
+// event. Must be called before any concurrent use of EnsureDeleteFinalizer.
+func RegisterIngressAfterFinalizerRemoved(hook finalizer.AfterFinalizerRemovedFunc) {
+	ingressFinalizerManager.OnAfterFinalizerRemoved(hook)
+	ingressV1FinalizerManager.OnAfterFinalizerRemoved(hook)
+}
+
+// RegisterServiceAfterFinalizerRemoved wires hook to run once the last
+// module-owned finalizer is cleared from a Service being deleted, whether
+// that happens through EnsureDeleteServiceFinalizer or is only observed
+// afterwards via CheckServiceAfterFinalizerRemoved. Must be called before any
+// concurrent use of EnsureDeleteServiceFinalizer.
+func RegisterServiceAfterFinalizerRemoved(hook finalizer.AfterFinalizerRemovedFunc) {
+	serviceFinalizerManager.OnAfterFinalizerRemoved(hook)
+}
+
+// CheckIngressAfterFinalizerRemoved reports an Ingress update observed by an
+// informer's UpdateFunc so RegisterIngressAfterFinalizerRemoved's hook still
+// fires when a user strips the finalizer out-of-band instead of through
+// EnsureDeleteFinalizer, e.g. handler.OnUpdate = func(old, new *v1beta1.Ingress) {
+// common.CheckIngressAfterFinalizerRemoved(old, new, ingClient) }.
+func CheckIngressAfterFinalizerRemoved(oldIng, newIng *v1beta1.Ingress, ingClient client.IngressInterface) {
+	ingressFinalizerManager.CheckAfterFinalizerRemoved(context.TODO(),
+		finalizer.NewIngressV1beta1Adapter(oldIng, ingClient),
+		finalizer.NewIngressV1beta1Adapter(newIng, ingClient))
+}
+
+// CheckIngressAfterFinalizerRemovedV1 is the networking/v1 Ingress equivalent
+// of CheckIngressAfterFinalizerRemoved.
+func CheckIngressAfterFinalizerRemovedV1(oldIng, newIng *networkingv1.Ingress, ingClient networkingv1client.IngressInterface) {
+	ingressV1FinalizerManager.CheckAfterFinalizerRemoved(context.TODO(),
+		finalizer.NewIngressV1Adapter(oldIng, ingClient),
+		finalizer.NewIngressV1Adapter(newIng, ingClient))
+}
+
+// CheckServiceAfterFinalizerRemoved is the Service equivalent of
+// CheckIngressAfterFinalizerRemoved.
+func CheckServiceAfterFinalizerRemoved(oldSvc, newSvc *corev1.Service, kubeClient kubernetes.Interface) {
+	serviceFinalizerManager.CheckAfterFinalizerRemoved(context.TODO(),
+		finalizer.NewServiceAdapter(oldSvc, kubeClient.CoreV1().Services(oldSvc.Namespace)),
+		finalizer.NewServiceAdapter(newSvc, kubeClient.CoreV1().Services(newSvc.Namespace)))
+}
+
+// SetIngressFinalizerMode switches EnsureFinalizer/EnsureDeleteFinalizer
+// between enforcing, dry-running, and auditing finalizer mutations on
+// Ingresses. Operators use ModeDryRun/ModeAuditOnly to preview which
+// Ingresses a V1/V2 finalizer migration would touch before flipping the
+// switch to ModeEnforce. It applies to networking/v1beta1 Ingresses only;
+// EnsureFinalizerV1/EnsureDeleteFinalizerV1 are not affected and keep
+// enforcing.
+func SetIngressFinalizerMode(mode finalizer.Mode) {
+	ingressFinalizerManager.SetMode(mode)
+}
+
+// SetServiceFinalizerMode is the Service equivalent of SetIngressFinalizerMode.
+func SetServiceFinalizerMode(mode finalizer.Mode) {
+	serviceFinalizerManager.SetMode(mode)
+}
+
+// IngressFinalizerAuditHandler exposes the ModeAuditOnly ring buffer for
+// Ingress finalizer mutations, for registration on a controller's debug mux.
+func IngressFinalizerAuditHandler() http.Handler {
+	return ingressFinalizerManager.AuditHandler()
+}
+
+// ServiceFinalizerAuditHandler is the Service equivalent of
+// IngressFinalizerAuditHandler.
+func ServiceFinalizerAuditHandler() http.Handler {
+	return serviceFinalizerManager.AuditHandler()
+}
+
 const (
 	// FinalizerKey is the string representing the Ingress finalizer.
 	FinalizerKey = "networking.gke.io/ingress-finalizer"
@@ -67,81 +160,65 @@ func HasGivenFinalizer(m meta_v1.ObjectMeta, key string) bool {
 
 // EnsureFinalizer ensures that the specified finalizer exists on given Ingress.
 func EnsureFinalizer(ing *v1beta1.Ingress, ingClient client.IngressInterface, finalizerKey string) (*v1beta1.Ingress, error) {
-	updated := ing.DeepCopy()
-	if needToAddFinalizer(ing.ObjectMeta, finalizerKey) {
-		updated.ObjectMeta.Finalizers = append(updated.ObjectMeta.Finalizers, finalizerKey)
-		if _, err := PatchIngressObjectMetadata(ingClient, ing, updated.ObjectMeta); err != nil {
-			return nil, fmt.Errorf("error patching Ingress %s/%s: %v", ing.Namespace, ing.Name, err)
-		}
-		klog.V(2).Infof("Added finalizer %q for Ingress %s/%s", finalizerKey, ing.Namespace, ing.Name)
+	adapter, err := ingressFinalizerManager.Add(context.TODO(), finalizer.NewIngressV1beta1Adapter(ing, ingClient), finalizerKey)
+	if err != nil {
+		return nil, err
 	}
-	return updated, nil
-}
-
-// needToAddFinalizer is true if the passed in meta does not contain the specified finalizer.
-func needToAddFinalizer(m meta_v1.ObjectMeta, key string) bool {
-	return m.DeletionTimestamp == nil && !HasGivenFinalizer(m, key)
+	return adapter.(*finalizer.IngressV1beta1Adapter).Ingress(), nil
 }
 
 // EnsureDeleteFinalizer ensures that the specified finalizer is deleted from given Ingress.
 func EnsureDeleteFinalizer(ing *v1beta1.Ingress, ingClient client.IngressInterface, finalizerKey string) error {
-	if HasGivenFinalizer(ing.ObjectMeta, finalizerKey) {
-		updatedObjectMeta := ing.ObjectMeta.DeepCopy()
-		updatedObjectMeta.Finalizers = slice.RemoveString(updatedObjectMeta.Finalizers, finalizerKey, nil)
-		if _, err := PatchIngressObjectMetadata(ingClient, ing, *updatedObjectMeta); err != nil {
-			return fmt.Errorf("error patching Ingress %s/%s: %v", ing.Namespace, ing.Name, err)
-		}
-		klog.V(2).Infof("Removed finalizer %q for Ingress %s/%s", finalizerKey, ing.Namespace, ing.Name)
-	}
-	return nil
+	_, err := ingressFinalizerManager.Remove(context.TODO(), finalizer.NewIngressV1beta1Adapter(ing, ingClient), finalizerKey)
+	return err
 }
 
-// EnsureServiceFinalizer patches the service to add finalizer.
-func EnsureServiceFinalizer(service *corev1.Service, key string, kubeClient kubernetes.Interface) error {
-	if HasGivenFinalizer(service.ObjectMeta, key) {
-		return nil
+// EnsureFinalizerV1 is the networking/v1 Ingress equivalent of EnsureFinalizer,
+// for controllers that have migrated off networking/v1beta1.
+func EnsureFinalizerV1(ing *networkingv1.Ingress, ingClient networkingv1client.IngressInterface, finalizerKey string) (*networkingv1.Ingress, error) {
+	adapter, err := ingressV1FinalizerManager.Add(context.TODO(), finalizer.NewIngressV1Adapter(ing, ingClient), finalizerKey)
+	if err != nil {
+		return nil, err
 	}
-
-	// Make a copy so we don't mutate the shared informer cache.
-	updated := service.DeepCopy()
-	updated.ObjectMeta.Finalizers = append(updated.ObjectMeta.Finalizers, key)
-
-	klog.V(2).Infof("Adding finalizer %s to service %s/%s", key, updated.Namespace, updated.Name)
-	return patchServiceFinalizer(kubeClient.CoreV1().Services(updated.Namespace), service, updated)
+	return adapter.(*finalizer.IngressV1Adapter).Ingress(), nil
 }
 
-// removeFinalizer patches the service to remove finalizer.
-func EnsureDeleteServiceFinalizer(service *corev1.Service, key string, kubeClient kubernetes.Interface) error {
-	if !HasGivenFinalizer(service.ObjectMeta, key) {
-		return nil
-	}
-
-	// Make a copy so we don't mutate the shared informer cache.
-	updated := service.DeepCopy()
-	updated.ObjectMeta.Finalizers = slice.RemoveString(updated.ObjectMeta.Finalizers, key, nil)
-
-	klog.V(2).Infof("Removing finalizer from service %s/%s", updated.Namespace, updated.Name)
-	return patchServiceFinalizer(kubeClient.CoreV1().Services(updated.Namespace), service, updated)
+// EnsureDeleteFinalizerV1 is the networking/v1 Ingress equivalent of
+// EnsureDeleteFinalizer.
+func EnsureDeleteFinalizerV1(ing *networkingv1.Ingress, ingClient networkingv1client.IngressInterface, finalizerKey string) error {
+	_, err := ingressV1FinalizerManager.Remove(context.TODO(), finalizer.NewIngressV1Adapter(ing, ingClient), finalizerKey)
+	return err
 }
 
-func patchServiceFinalizer(sc coreclient.ServiceInterface, oldSvc, newSvc *corev1.Service) error {
-	svcKey := fmt.Sprintf("%s/%s", oldSvc.Namespace, oldSvc.Name)
-	oldData, err := json.Marshal(oldSvc)
-	if err != nil {
-		return fmt.Errorf("failed to Marshal oldData for service %s: %v", svcKey, err)
-	}
-
-	newData, err := json.Marshal(newSvc)
-	if err != nil {
-		return fmt.Errorf("failed to Marshal newData for service %s: %v", svcKey, err)
+// ErrWaitingForFinalizers is returned by EnsureDeleteFinalizerAfter while one
+// or more of waitForKeys is still present on the object, so callers can
+// distinguish "not ready yet" from a real error and requeue instead of
+// failing the sync.
+var ErrWaitingForFinalizers = errors.New("waiting for prerequisite finalizers to clear before removing finalizer")
+
+// EnsureDeleteFinalizerAfter removes finalizerKey from ing only once every
+// finalizer in waitForKeys has already cleared, letting a controller declare
+// an ordering constraint between its own finalizer and another controller's,
+// e.g. the NEG finalizer must outlive the Ingress finalizer so backends are
+// detached before NEGs are garbage collected. While a prerequisite is still
+// present, it returns ErrWaitingForFinalizers without touching the API.
+func EnsureDeleteFinalizerAfter(ing *v1beta1.Ingress, ingClient client.IngressInterface, finalizerKey string, waitForKeys ...string) error {
+	for _, waitKey := range waitForKeys {
+		if HasGivenFinalizer(ing.ObjectMeta, waitKey) {
+			return ErrWaitingForFinalizers
+		}
 	}
+	return EnsureDeleteFinalizer(ing, ingClient, finalizerKey)
+}
 
-	patchBytes, err := strategicpatch.CreateTwoWayMergePatch(oldData, newData, corev1.Service{})
-	if err != nil {
-		return fmt.Errorf("failed to create TwoWayMergePatch for service %s: %v", svcKey, err)
-	}
+// EnsureServiceFinalizer patches the service to add finalizer.
+func EnsureServiceFinalizer(service *corev1.Service, key string, kubeClient kubernetes.Interface) error {
+	_, err := serviceFinalizerManager.Add(context.TODO(), finalizer.NewServiceAdapter(service, kubeClient.CoreV1().Services(service.Namespace)), key)
+	return err
+}
 
-	klog.V(3).Infof("Patch bytes for service %s: %s", svcKey, patchBytes)
-	_, err = sc.Patch(context.TODO(), oldSvc.Name, types.StrategicMergePatchType, patchBytes, meta_v1.PatchOptions{}, "status")
+// EnsureDeleteServiceFinalizer patches the service to remove finalizer.
+func EnsureDeleteServiceFinalizer(service *corev1.Service, key string, kubeClient kubernetes.Interface) error {
+	_, err := serviceFinalizerManager.Remove(context.TODO(), finalizer.NewServiceAdapter(service, kubeClient.CoreV1().Services(service.Namespace)), key)
 	return err
 }
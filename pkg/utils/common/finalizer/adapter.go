@@ -0,0 +1,63 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package finalizer
+
+import (
+	"context"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// ResourceAdapter lets FinalizerManager operate on a concrete Kubernetes
+// resource (Ingress, Service, or one of the module's CRDs) without knowing
+// its concrete type. Implementations must be safe to DeepCopy and Marshal
+// independently of the informer cache they were read from.
+type ResourceAdapter interface {
+	// GetObjectMeta returns the ObjectMeta of the wrapped resource.
+	GetObjectMeta() meta_v1.ObjectMeta
+
+	// SetFinalizers overwrites the Finalizers of the wrapped resource.
+	SetFinalizers(finalizers []string)
+
+	// DeepCopy returns a copy of the adapter wrapping a deep copy of the
+	// underlying resource, so callers can mutate Finalizers without
+	// touching the informer cache.
+	DeepCopy() ResourceAdapter
+
+	// Marshal returns the JSON encoding of the underlying resource, used
+	// to compute a strategic merge patch against another revision.
+	Marshal() ([]byte, error)
+
+	// Unmarshal decodes data into the underlying resource. It is used to
+	// materialize the object returned by a GET prior to retrying a patch.
+	Unmarshal(data []byte) error
+
+	// Patch applies patchBytes to the resource identified by this adapter
+	// and returns an adapter wrapping the server's response.
+	Patch(ctx context.Context, patchBytes []byte) (ResourceAdapter, error)
+
+	// Get re-fetches the resource from the API server, bypassing the
+	// informer cache, so a retried patch is computed against the live
+	// object rather than a stale one.
+	Get(ctx context.Context) (ResourceAdapter, error)
+
+	// Key returns the namespace/name of the resource, used for logging and
+	// metrics.
+	Key() string
+
+	// Object returns the underlying resource as a runtime.Object, used to
+	// record Kubernetes Events against it.
+	Object() runtime.Object
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package finalizer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/api/networking/v1beta1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	coreclient "k8s.io/client-go/kubernetes/typed/core/v1"
+	networkingv1client "k8s.io/client-go/kubernetes/typed/networking/v1"
+	ingressclient "k8s.io/client-go/kubernetes/typed/networking/v1beta1"
+)
+
+// IngressV1beta1Adapter wraps a networking/v1beta1 Ingress so it can be
+// driven by FinalizerManager.
+type IngressV1beta1Adapter struct {
+	ing    *v1beta1.Ingress
+	client ingressclient.IngressInterface
+}
+
+// NewIngressV1beta1Adapter returns a ResourceAdapter for ing.
+func NewIngressV1beta1Adapter(ing *v1beta1.Ingress, client ingressclient.IngressInterface) *IngressV1beta1Adapter {
+	return &IngressV1beta1Adapter{ing: ing, client: client}
+}
+
+// Ingress returns the underlying networking/v1beta1 Ingress, for callers that
+// need the concrete type back (e.g. existing EnsureFinalizer signatures).
+func (a *IngressV1beta1Adapter) Ingress() *v1beta1.Ingress { return a.ing }
+
+func (a *IngressV1beta1Adapter) GetObjectMeta() meta_v1.ObjectMeta { return a.ing.ObjectMeta }
+
+func (a *IngressV1beta1Adapter) SetFinalizers(finalizers []string) {
+	a.ing.ObjectMeta.Finalizers = finalizers
+}
+
+func (a *IngressV1beta1Adapter) DeepCopy() ResourceAdapter {
+	return &IngressV1beta1Adapter{ing: a.ing.DeepCopy(), client: a.client}
+}
+
+func (a *IngressV1beta1Adapter) Marshal() ([]byte, error) { return json.Marshal(a.ing) }
+
+func (a *IngressV1beta1Adapter) Unmarshal(data []byte) error { return json.Unmarshal(data, a.ing) }
+
+func (a *IngressV1beta1Adapter) Patch(ctx context.Context, patchBytes []byte) (ResourceAdapter, error) {
+	updated, err := a.client.Patch(ctx, a.ing.Name, types.StrategicMergePatchType, patchBytes, meta_v1.PatchOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &IngressV1beta1Adapter{ing: updated, client: a.client}, nil
+}
+
+func (a *IngressV1beta1Adapter) Get(ctx context.Context) (ResourceAdapter, error) {
+	got, err := a.client.Get(ctx, a.ing.Name, meta_v1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &IngressV1beta1Adapter{ing: got, client: a.client}, nil
+}
+
+func (a *IngressV1beta1Adapter) Key() string {
+	return fmt.Sprintf("Ingress %s/%s", a.ing.Namespace, a.ing.Name)
+}
+
+func (a *IngressV1beta1Adapter) Object() runtime.Object { return a.ing }
+
+// IngressV1Adapter wraps a networking/v1 Ingress so it can be driven by
+// FinalizerManager.
+type IngressV1Adapter struct {
+	ing    *networkingv1.Ingress
+	client networkingv1client.IngressInterface
+}
+
+// NewIngressV1Adapter returns a ResourceAdapter for ing.
+func NewIngressV1Adapter(ing *networkingv1.Ingress, client networkingv1client.IngressInterface) *IngressV1Adapter {
+	return &IngressV1Adapter{ing: ing, client: client}
+}
+
+// Ingress returns the underlying networking/v1 Ingress.
+func (a *IngressV1Adapter) Ingress() *networkingv1.Ingress { return a.ing }
+
+func (a *IngressV1Adapter) GetObjectMeta() meta_v1.ObjectMeta { return a.ing.ObjectMeta }
+
+func (a *IngressV1Adapter) SetFinalizers(finalizers []string) {
+	a.ing.ObjectMeta.Finalizers = finalizers
+}
+
+func (a *IngressV1Adapter) DeepCopy() ResourceAdapter {
+	return &IngressV1Adapter{ing: a.ing.DeepCopy(), client: a.client}
+}
+
+func (a *IngressV1Adapter) Marshal() ([]byte, error) { return json.Marshal(a.ing) }
+
+func (a *IngressV1Adapter) Unmarshal(data []byte) error { return json.Unmarshal(data, a.ing) }
+
+func (a *IngressV1Adapter) Patch(ctx context.Context, patchBytes []byte) (ResourceAdapter, error) {
+	updated, err := a.client.Patch(ctx, a.ing.Name, types.StrategicMergePatchType, patchBytes, meta_v1.PatchOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &IngressV1Adapter{ing: updated, client: a.client}, nil
+}
+
+func (a *IngressV1Adapter) Get(ctx context.Context) (ResourceAdapter, error) {
+	got, err := a.client.Get(ctx, a.ing.Name, meta_v1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &IngressV1Adapter{ing: got, client: a.client}, nil
+}
+
+func (a *IngressV1Adapter) Key() string {
+	return fmt.Sprintf("Ingress %s/%s", a.ing.Namespace, a.ing.Name)
+}
+
+func (a *IngressV1Adapter) Object() runtime.Object { return a.ing }
+
+// ServiceAdapter wraps a corev1 Service so it can be driven by
+// FinalizerManager.
+type ServiceAdapter struct {
+	svc    *corev1.Service
+	client coreclient.ServiceInterface
+}
+
+// NewServiceAdapter returns a ResourceAdapter for svc.
+func NewServiceAdapter(svc *corev1.Service, client coreclient.ServiceInterface) *ServiceAdapter {
+	return &ServiceAdapter{svc: svc, client: client}
+}
+
+// Service returns the underlying corev1 Service.
+func (a *ServiceAdapter) Service() *corev1.Service { return a.svc }
+
+func (a *ServiceAdapter) GetObjectMeta() meta_v1.ObjectMeta { return a.svc.ObjectMeta }
+
+func (a *ServiceAdapter) SetFinalizers(finalizers []string) {
+	a.svc.ObjectMeta.Finalizers = finalizers
+}
+
+func (a *ServiceAdapter) DeepCopy() ResourceAdapter {
+	return &ServiceAdapter{svc: a.svc.DeepCopy(), client: a.client}
+}
+
+func (a *ServiceAdapter) Marshal() ([]byte, error) { return json.Marshal(a.svc) }
+
+func (a *ServiceAdapter) Unmarshal(data []byte) error { return json.Unmarshal(data, a.svc) }
+
+func (a *ServiceAdapter) Patch(ctx context.Context, patchBytes []byte) (ResourceAdapter, error) {
+	updated, err := a.client.Patch(ctx, a.svc.Name, types.StrategicMergePatchType, patchBytes, meta_v1.PatchOptions{}, "status")
+	if err != nil {
+		return nil, err
+	}
+	return &ServiceAdapter{svc: updated, client: a.client}, nil
+}
+
+func (a *ServiceAdapter) Get(ctx context.Context) (ResourceAdapter, error) {
+	got, err := a.client.Get(ctx, a.svc.Name, meta_v1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &ServiceAdapter{svc: got, client: a.client}, nil
+}
+
+func (a *ServiceAdapter) Key() string {
+	return fmt.Sprintf("Service %s/%s", a.svc.Namespace, a.svc.Name)
+}
+
+func (a *ServiceAdapter) Object() runtime.Object { return a.svc }
@@ -0,0 +1,80 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package finalizer
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AuditRecord describes one finalizer mutation that ModeAuditOnly observed
+// but did not apply.
+type AuditRecord struct {
+	Time         time.Time `json:"time"`
+	Key          string    `json:"key"`
+	FinalizerKey string    `json:"finalizerKey"`
+	Operation    string    `json:"operation"`
+	PatchBytes   string    `json:"patchBytes"`
+}
+
+// auditRingBuffer keeps the most recent audit records in memory so an
+// operator can preview, via a controller's debug endpoint, which objects
+// would be touched before flipping a finalizer migration to ModeEnforce.
+type auditRingBuffer struct {
+	mu      sync.Mutex
+	records []AuditRecord
+	cap     int
+	next    int
+	full    bool
+}
+
+func newAuditRingBuffer(capacity int) *auditRingBuffer {
+	return &auditRingBuffer{records: make([]AuditRecord, capacity), cap: capacity}
+}
+
+func (b *auditRingBuffer) add(r AuditRecord) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.records[b.next] = r
+	b.next = (b.next + 1) % b.cap
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// Snapshot returns the buffered records, oldest first.
+func (b *auditRingBuffer) Snapshot() []AuditRecord {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.full {
+		out := make([]AuditRecord, b.next)
+		copy(out, b.records[:b.next])
+		return out
+	}
+	out := make([]AuditRecord, b.cap)
+	copy(out, b.records[b.next:])
+	copy(out[b.cap-b.next:], b.records[:b.next])
+	return out
+}
+
+// ServeHTTP renders the buffered audit records as JSON. Controllers can
+// register this on their debug mux, e.g. mux.Handle("/debug/finalizer-audit", manager.AuditHandler()).
+func (b *auditRingBuffer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(b.Snapshot()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
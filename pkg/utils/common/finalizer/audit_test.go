@@ -0,0 +1,93 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package finalizer
+
+import (
+	"testing"
+)
+
+func record(key string) AuditRecord {
+	return AuditRecord{Key: key}
+}
+
+func keys(records []AuditRecord) []string {
+	out := make([]string, 0, len(records))
+	for _, r := range records {
+		out = append(out, r.Key)
+	}
+	return out
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestAuditRingBufferSnapshot(t *testing.T) {
+	for _, tc := range []struct {
+		desc     string
+		capacity int
+		adds     []string
+		want     []string
+	}{
+		{
+			desc:     "not yet full returns only what was added, oldest first",
+			capacity: 3,
+			adds:     []string{"a", "b"},
+			want:     []string{"a", "b"},
+		},
+		{
+			desc:     "exactly full returns everything in insertion order",
+			capacity: 3,
+			adds:     []string{"a", "b", "c"},
+			want:     []string{"a", "b", "c"},
+		},
+		{
+			desc:     "wraps once, dropping the oldest entries",
+			capacity: 3,
+			adds:     []string{"a", "b", "c", "d"},
+			want:     []string{"b", "c", "d"},
+		},
+		{
+			desc:     "wraps multiple times",
+			capacity: 3,
+			adds:     []string{"a", "b", "c", "d", "e", "f", "g"},
+			want:     []string{"e", "f", "g"},
+		},
+		{
+			desc:     "empty buffer returns empty slice",
+			capacity: 3,
+			adds:     nil,
+			want:     []string{},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			b := newAuditRingBuffer(tc.capacity)
+			for _, key := range tc.adds {
+				b.add(record(key))
+			}
+			got := keys(b.Snapshot())
+			if !equal(got, tc.want) {
+				t.Errorf("Snapshot() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package finalizer implements a generic finalizer manager for resource types
+// that need to add/remove finalizers before the resource can be garbage
+// collected. Each resource type plugs into the manager by implementing the
+// ResourceAdapter interface instead of re-implementing its own add/remove/has
+// plumbing. The package ships adapters for networking/v1beta1 Ingress,
+// networking/v1 Ingress and corev1 Service only; it has none for the module's
+// CRDs.
+package finalizer
@@ -0,0 +1,337 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package finalizer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog"
+	"k8s.io/kubernetes/pkg/util/slice"
+)
+
+// auditRingBufferCapacity bounds how many ModeAuditOnly records are kept in
+// memory for the debug endpoint.
+const auditRingBufferCapacity = 200
+
+// finalizerPatchBackoff bounds conflict retries for a single finalizer patch:
+// 5 attempts, starting at 10ms and doubling each time, so a hot finalizer key
+// is retried for at most ~150ms before giving up and surfacing the conflict
+// to the caller's sync loop.
+var finalizerPatchBackoff = wait.Backoff{
+	Duration: 10 * time.Millisecond,
+	Factor:   2.0,
+	Jitter:   0.1,
+	Steps:    5,
+}
+
+// AfterFinalizerRemovedFunc is invoked once the last module-owned finalizer
+// has cleared from an object that is being deleted, whether that happened
+// through this manager's own Remove or was only observed afterwards via
+// CheckAfterFinalizerRemoved. It lets a controller reconcile external
+// (GCE-side) resource release without depending on an informer delivering a
+// subsequent delete event, which never happens if a user strips the
+// finalizer out-of-band (e.g. via a status patch). Because it can fire twice
+// for the same transition (once from Remove's own patch response, again from
+// a later CheckAfterFinalizerRemoved call), it must be idempotent.
+type AfterFinalizerRemovedFunc func(ctx context.Context, adapter ResourceAdapter)
+
+// FinalizerManager implements Add/Remove/Has/IsDeletionCandidate once for any
+// resource type that provides a ResourceAdapter, so individual controllers no
+// longer need their own copy of the add/remove/patch plumbing.
+type FinalizerManager struct {
+	// emptyPatchObj is passed to strategicpatch.CreateTwoWayMergePatch as
+	// the struct describing the patch schema (e.g. corev1.Service{}).
+	emptyPatchObj interface{}
+	// moduleKeys are the finalizer keys this module owns on the resource
+	// type managed by this FinalizerManager, used to recognize when Remove
+	// has taken off the last one.
+	moduleKeys []string
+	// afterRemoved, if set, runs after Remove clears the last of moduleKeys
+	// from an object with a DeletionTimestamp.
+	afterRemoved AfterFinalizerRemovedFunc
+	// modeMu guards mode, since SetMode is meant to be flipped on a live
+	// cluster (e.g. from an admin/debug endpoint) concurrently with
+	// controllers calling Add/Remove.
+	modeMu sync.RWMutex
+	// mode controls whether Add/Remove actually patch the API server.
+	// Access through setMode/getMode, not directly.
+	mode Mode
+	// recorder, if set, receives an Event describing the change that
+	// ModeDryRun/ModeAuditOnly would have made.
+	recorder record.EventRecorder
+	// audit buffers the most recent ModeAuditOnly records.
+	audit *auditRingBuffer
+}
+
+// NewFinalizerManager returns a FinalizerManager that computes strategic
+// merge patches using emptyPatchObj's type, e.g. NewFinalizerManager(corev1.Service{}, moduleKeys).
+// moduleKeys lists every finalizer key this module may place on the
+// resource, so Remove can tell when the last one has cleared. It starts in
+// ModeEnforce.
+func NewFinalizerManager(emptyPatchObj interface{}, moduleKeys []string) *FinalizerManager {
+	return &FinalizerManager{
+		emptyPatchObj: emptyPatchObj,
+		moduleKeys:    moduleKeys,
+		audit:         newAuditRingBuffer(auditRingBufferCapacity),
+	}
+}
+
+// OnAfterFinalizerRemoved registers hook to run after Remove clears the last
+// module-owned finalizer from a deleted object. It is not safe to call
+// concurrently with Remove.
+func (m *FinalizerManager) OnAfterFinalizerRemoved(hook AfterFinalizerRemovedFunc) {
+	m.afterRemoved = hook
+}
+
+// SetMode changes whether Add/Remove enforce, dry-run, or audit finalizer
+// mutations. It is safe to call concurrently with Add/Remove, e.g. from an
+// admin/debug endpoint flipping a live controller between modes.
+func (m *FinalizerManager) SetMode(mode Mode) {
+	m.modeMu.Lock()
+	defer m.modeMu.Unlock()
+	m.mode = mode
+}
+
+// getMode returns the current mode, safe for concurrent use with SetMode.
+func (m *FinalizerManager) getMode() Mode {
+	m.modeMu.RLock()
+	defer m.modeMu.RUnlock()
+	return m.mode
+}
+
+// SetEventRecorder wires an EventRecorder used by ModeDryRun/ModeAuditOnly to
+// surface "would add/remove finalizer" Events on the affected object.
+func (m *FinalizerManager) SetEventRecorder(recorder record.EventRecorder) {
+	m.recorder = recorder
+}
+
+// AuditHandler returns an http.Handler that renders the ModeAuditOnly ring
+// buffer as JSON, for registration on a controller's debug mux, e.g.
+// mux.Handle("/debug/finalizer-audit", manager.AuditHandler()).
+func (m *FinalizerManager) AuditHandler() http.Handler {
+	return m.audit
+}
+
+// hasAnyModuleKey is true if fin contains any of m.moduleKeys.
+func (m *FinalizerManager) hasAnyModuleKey(fin []string) bool {
+	for _, key := range m.moduleKeys {
+		if slice.ContainsString(fin, key, nil) {
+			return true
+		}
+	}
+	return false
+}
+
+// Has is true if adapter's resource currently has the given finalizer.
+func (m *FinalizerManager) Has(adapter ResourceAdapter, key string) bool {
+	return slice.ContainsString(adapter.GetObjectMeta().Finalizers, key, nil)
+}
+
+// IsDeletionCandidate is true if adapter's resource is being deleted and
+// still carries the given finalizer.
+func (m *FinalizerManager) IsDeletionCandidate(adapter ResourceAdapter, key string) bool {
+	return adapter.GetObjectMeta().DeletionTimestamp != nil && m.Has(adapter, key)
+}
+
+// Add ensures the given finalizer is present on adapter's resource, patching
+// the API server if it is missing. An object that already has a
+// DeletionTimestamp is returned unchanged. It returns an adapter wrapping the
+// (possibly updated) resource. On a 409 Conflict, it re-fetches the live
+// object (bypassing whatever cache adapter was read from) and recomputes the
+// patch, up to finalizerPatchBackoff's retry budget.
+func (m *FinalizerManager) Add(ctx context.Context, adapter ResourceAdapter, key string) (ResourceAdapter, error) {
+	if adapter.GetObjectMeta().DeletionTimestamp != nil || m.Has(adapter, key) {
+		return adapter.DeepCopy(), nil
+	}
+
+	mutate := func(current ResourceAdapter) (ResourceAdapter, bool) {
+		if m.Has(current, key) {
+			return current.DeepCopy(), true
+		}
+		updated := current.DeepCopy()
+		updated.SetFinalizers(append(updated.GetObjectMeta().Finalizers, key))
+		return updated, false
+	}
+
+	if mode := m.getMode(); mode != ModeEnforce {
+		return m.simulate(mode, adapter, key, "add", mutate)
+	}
+
+	result, err := m.retryPatch(ctx, adapter, key, "add", mutate)
+	if err != nil {
+		return nil, fmt.Errorf("error adding finalizer %q to %s: %v", key, adapter.Key(), err)
+	}
+	klog.V(2).Infof("Added finalizer %q to %s", key, adapter.Key())
+	opsCount.WithLabelValues("add").Inc()
+	return result, nil
+}
+
+// Remove ensures the given finalizer is absent from adapter's resource,
+// patching the API server if it is present. Conflicts are retried the same
+// way as Add.
+func (m *FinalizerManager) Remove(ctx context.Context, adapter ResourceAdapter, key string) (ResourceAdapter, error) {
+	if !m.Has(adapter, key) {
+		return adapter.DeepCopy(), nil
+	}
+
+	mutate := func(current ResourceAdapter) (ResourceAdapter, bool) {
+		if !m.Has(current, key) {
+			return current.DeepCopy(), true
+		}
+		updated := current.DeepCopy()
+		updated.SetFinalizers(slice.RemoveString(updated.GetObjectMeta().Finalizers, key, nil))
+		return updated, false
+	}
+
+	if mode := m.getMode(); mode != ModeEnforce {
+		return m.simulate(mode, adapter, key, "remove", mutate)
+	}
+
+	result, err := m.retryPatch(ctx, adapter, key, "remove", mutate)
+	if err != nil {
+		return nil, fmt.Errorf("error removing finalizer %q from %s: %v", key, adapter.Key(), err)
+	}
+	klog.V(2).Infof("Removed finalizer %q from %s", key, adapter.Key())
+	opsCount.WithLabelValues("remove").Inc()
+
+	m.maybeFireAfterRemoved(ctx, adapter, result)
+	return result, nil
+}
+
+// maybeFireAfterRemoved runs the AfterFinalizerRemoved hook if oldAdapter
+// still carried a module-owned finalizer while newAdapter, now being
+// deleted, no longer does.
+func (m *FinalizerManager) maybeFireAfterRemoved(ctx context.Context, oldAdapter, newAdapter ResourceAdapter) {
+	if m.afterRemoved == nil {
+		return
+	}
+	newMeta := newAdapter.GetObjectMeta()
+	if newMeta.DeletionTimestamp == nil || !m.hasAnyModuleKey(oldAdapter.GetObjectMeta().Finalizers) || m.hasAnyModuleKey(newMeta.Finalizers) {
+		return
+	}
+	klog.V(2).Infof("Last module-owned finalizer removed from %s, running AfterFinalizerRemoved hook", newAdapter.Key())
+	m.afterRemoved(ctx, newAdapter)
+}
+
+// CheckAfterFinalizerRemoved lets a controller report a finalizer-cleared
+// transition it observed somewhere other than its own Remove call, e.g. an
+// informer's UpdateFunc comparing old and new, and runs AfterFinalizerRemoved
+// if it qualifies. This is what makes the hook fire when a user force-patches
+// the finalizer away out-of-band: in that case Remove is never called, so
+// without this the module would depend on a delete event that never comes.
+func (m *FinalizerManager) CheckAfterFinalizerRemoved(ctx context.Context, oldAdapter, newAdapter ResourceAdapter) {
+	m.maybeFireAfterRemoved(ctx, oldAdapter, newAdapter)
+}
+
+// retryPatch drives a single finalizer mutation to completion, retrying on
+// conflict against a freshly-fetched object. mutate returns the desired
+// post-mutation adapter and whether the mutation is already a no-op (in
+// which case the current adapter is returned unpatched).
+func (m *FinalizerManager) retryPatch(ctx context.Context, adapter ResourceAdapter, key, operation string, mutate func(current ResourceAdapter) (updated ResourceAdapter, noop bool)) (ResourceAdapter, error) {
+	current := adapter
+	var result ResourceAdapter
+	err := retry.RetryOnConflict(finalizerPatchBackoff, func() error {
+		updated, noop := mutate(current)
+		if noop {
+			result = updated
+			return nil
+		}
+
+		patched, err := m.patch(ctx, current, updated)
+		if err != nil {
+			if apierrors.IsConflict(err) {
+				retryCount.WithLabelValues(key, operation).Inc()
+				fresh, getErr := current.Get(ctx)
+				if getErr != nil {
+					return getErr
+				}
+				current = fresh
+			}
+			return err
+		}
+		result = patched
+		return nil
+	})
+	return result, err
+}
+
+// patch computes a strategic merge patch between old and updated and applies
+// it through old's adapter.
+func (m *FinalizerManager) patch(ctx context.Context, old, updated ResourceAdapter) (ResourceAdapter, error) {
+	patchBytes, err := m.computePatchBytes(old, updated)
+	if err != nil {
+		return nil, err
+	}
+	klog.V(3).Infof("Patch bytes for %s: %s", old.Key(), patchBytes)
+	return old.Patch(ctx, patchBytes)
+}
+
+// computePatchBytes returns the strategic merge patch between old and
+// updated without applying it.
+func (m *FinalizerManager) computePatchBytes(old, updated ResourceAdapter) ([]byte, error) {
+	oldData, err := old.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s: %v", old.Key(), err)
+	}
+	newData, err := updated.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s: %v", updated.Key(), err)
+	}
+	patchBytes, err := strategicpatch.CreateTwoWayMergePatch(oldData, newData, m.emptyPatchObj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create merge patch for %s: %v", old.Key(), err)
+	}
+	return patchBytes, nil
+}
+
+// simulate implements ModeDryRun/ModeAuditOnly for Add/Remove: it computes
+// the patch that would be sent, logs it, emits an Event if a recorder is set, and for
+// ModeAuditOnly records it to the audit ring buffer, but never calls the API
+// server.
+func (m *FinalizerManager) simulate(mode Mode, adapter ResourceAdapter, key, operation string, mutate func(current ResourceAdapter) (updated ResourceAdapter, noop bool)) (ResourceAdapter, error) {
+	updated, noop := mutate(adapter)
+	if noop {
+		return updated, nil
+	}
+
+	patchBytes, err := m.computePatchBytes(adapter, updated)
+	if err != nil {
+		return nil, fmt.Errorf("error computing %s finalizer %q patch for %s: %v", operation, key, adapter.Key(), err)
+	}
+	klog.V(2).Infof("[%s] would %s finalizer %q on %s, patch: %s", mode, operation, key, adapter.Key(), patchBytes)
+
+	if m.recorder != nil {
+		m.recorder.Eventf(adapter.Object(), corev1.EventTypeNormal, "FinalizerDryRun", "would %s finalizer %q", operation, key)
+	}
+	if mode == ModeAuditOnly {
+		m.audit.add(AuditRecord{
+			Time:         time.Now(),
+			Key:          adapter.Key(),
+			FinalizerKey: key,
+			Operation:    operation,
+			PatchBytes:   string(patchBytes),
+		})
+	}
+	return adapter, nil
+}
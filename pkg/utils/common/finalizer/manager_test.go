@@ -0,0 +1,180 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package finalizer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// fakeStore stands in for the API server behind a fakeAdapter: Patch applies
+// the incoming merge patch to the stored object (after failing
+// conflictsRemaining times), and Get returns the current stored object.
+type fakeStore struct {
+	mu                 sync.Mutex
+	live               *corev1.Service
+	conflictsRemaining int
+	patchCalls         int
+}
+
+func (s *fakeStore) patch(patchBytes []byte) (*corev1.Service, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.patchCalls++
+	if s.conflictsRemaining > 0 {
+		s.conflictsRemaining--
+		return nil, apierrors.NewConflict(schema.GroupResource{Resource: "services"}, s.live.Name, fmt.Errorf("conflict"))
+	}
+	var decoded struct {
+		Metadata struct {
+			Finalizers []string `json:"finalizers"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(patchBytes, &decoded); err != nil {
+		return nil, err
+	}
+	s.live = s.live.DeepCopy()
+	s.live.Finalizers = decoded.Metadata.Finalizers
+	return s.live.DeepCopy(), nil
+}
+
+func (s *fakeStore) get() *corev1.Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.live.DeepCopy()
+}
+
+// fakeAdapter is a minimal ResourceAdapter backed by a fakeStore, used to
+// exercise FinalizerManager's conflict-retry logic without a real API
+// server or fake clientset.
+type fakeAdapter struct {
+	svc   *corev1.Service
+	store *fakeStore
+}
+
+func (a *fakeAdapter) GetObjectMeta() meta_v1.ObjectMeta { return a.svc.ObjectMeta }
+
+func (a *fakeAdapter) SetFinalizers(finalizers []string) { a.svc.ObjectMeta.Finalizers = finalizers }
+
+func (a *fakeAdapter) DeepCopy() ResourceAdapter {
+	return &fakeAdapter{svc: a.svc.DeepCopy(), store: a.store}
+}
+
+func (a *fakeAdapter) Marshal() ([]byte, error) { return json.Marshal(a.svc) }
+
+func (a *fakeAdapter) Unmarshal(data []byte) error { return json.Unmarshal(data, a.svc) }
+
+func (a *fakeAdapter) Patch(ctx context.Context, patchBytes []byte) (ResourceAdapter, error) {
+	updated, err := a.store.patch(patchBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeAdapter{svc: updated, store: a.store}, nil
+}
+
+func (a *fakeAdapter) Get(ctx context.Context) (ResourceAdapter, error) {
+	return &fakeAdapter{svc: a.store.get(), store: a.store}, nil
+}
+
+func (a *fakeAdapter) Key() string { return a.svc.Namespace + "/" + a.svc.Name }
+
+func (a *fakeAdapter) Object() runtime.Object { return a.svc }
+
+func TestFinalizerManagerAddRetriesOnConflict(t *testing.T) {
+	svc := &corev1.Service{}
+	svc.Namespace = "ns"
+	svc.Name = "svc"
+	store := &fakeStore{live: svc.DeepCopy(), conflictsRemaining: 2}
+	adapter := &fakeAdapter{svc: svc.DeepCopy(), store: store}
+
+	m := NewFinalizerManager(corev1.Service{}, []string{"my-finalizer"})
+	result, err := m.Add(context.Background(), adapter, "my-finalizer")
+	if err != nil {
+		t.Fatalf("Add() returned error: %v", err)
+	}
+	if !m.Has(result, "my-finalizer") {
+		t.Errorf("Add() result missing finalizer: %v", result.GetObjectMeta().Finalizers)
+	}
+	if got, want := store.patchCalls, 3; got != want {
+		t.Errorf("patchCalls = %d, want %d (2 conflicts + 1 success)", got, want)
+	}
+	if got := store.get().Finalizers; len(got) != 1 || got[0] != "my-finalizer" {
+		t.Errorf("live object finalizers = %v, want [my-finalizer]", got)
+	}
+}
+
+// TestFinalizerManagerSetModeConcurrentWithAdd exercises SetMode racing with
+// Add the way an admin/debug endpoint flipping a live controller
+// between modes would: run with -race to catch a regression.
+func TestFinalizerManagerSetModeConcurrentWithAdd(t *testing.T) {
+	m := NewFinalizerManager(corev1.Service{}, []string{"my-finalizer"})
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	stop := make(chan struct{})
+	go func() {
+		defer wg.Done()
+		modes := []Mode{ModeEnforce, ModeDryRun, ModeAuditOnly}
+		i := 0
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				m.SetMode(modes[i%len(modes)])
+				i++
+			}
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			svc := &corev1.Service{}
+			svc.Namespace = "ns"
+			svc.Name = fmt.Sprintf("svc-%d", i)
+			store := &fakeStore{live: svc.DeepCopy()}
+			adapter := &fakeAdapter{svc: svc.DeepCopy(), store: store}
+			if _, err := m.Add(context.Background(), adapter, "my-finalizer"); err != nil {
+				t.Errorf("Add() returned error: %v", err)
+			}
+		}
+		close(stop)
+	}()
+
+	wg.Wait()
+}
+
+func TestFinalizerManagerAddGivesUpAfterBackoffExhausted(t *testing.T) {
+	svc := &corev1.Service{}
+	svc.Namespace = "ns"
+	svc.Name = "svc"
+	store := &fakeStore{live: svc.DeepCopy(), conflictsRemaining: 100}
+	adapter := &fakeAdapter{svc: svc.DeepCopy(), store: store}
+
+	m := NewFinalizerManager(corev1.Service{}, []string{"my-finalizer"})
+	if _, err := m.Add(context.Background(), adapter, "my-finalizer"); err == nil {
+		t.Fatal("Add() with unresolvable conflicts returned nil error, want non-nil")
+	}
+}
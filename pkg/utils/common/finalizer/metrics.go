@@ -0,0 +1,57 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package finalizer
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// opsCount tracks the number of finalizer add/remove operations that
+// succeeded, broken down by operation ("add" or "remove").
+var opsCount = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "finalizer_manager_operations_total",
+		Help: "Count of successful finalizer add/remove operations by operation type",
+	},
+	[]string{"operation"},
+)
+
+// retryCount tracks how many times a finalizer patch hit a 409 Conflict and
+// had to be retried against a freshly fetched object, broken down by
+// finalizer key and operation. A climbing rate here points at dual
+// controllers racing to patch the same object, e.g. during a V1/V2
+// finalizer migration.
+var retryCount = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "finalizer_manager_patch_retries_total",
+		Help: "Count of finalizer patch conflicts that were retried, by finalizer key and operation",
+	},
+	[]string{"finalizer_key", "operation"},
+)
+
+// stuckObjectsGauge tracks, per finalizer key, how many objects are past the
+// Sweeper's staleThreshold while still being deleted, i.e. likely orphans.
+var stuckObjectsGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "finalizer_manager_stuck_objects",
+		Help: "Number of objects whose deletion has been pending longer than the sweeper's stale threshold while still carrying the given finalizer",
+	},
+	[]string{"finalizer_key"},
+)
+
+func init() {
+	prometheus.MustRegister(opsCount)
+	prometheus.MustRegister(retryCount)
+	prometheus.MustRegister(stuckObjectsGauge)
+}
@@ -0,0 +1,45 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package finalizer
+
+// Mode controls whether FinalizerManager actually mutates objects.
+type Mode int
+
+const (
+	// ModeEnforce patches finalizers on the API server as usual. This is
+	// the default mode.
+	ModeEnforce Mode = iota
+	// ModeDryRun computes the patch and logs it and, when an EventRecorder
+	// has been set with SetEventRecorder, emits a Kubernetes Event
+	// describing the change that would have been made, but never calls the
+	// API server.
+	ModeDryRun
+	// ModeAuditOnly behaves like ModeDryRun and additionally records the
+	// computed change to an in-memory ring buffer that can be exposed on a
+	// controller's debug endpoint.
+	ModeAuditOnly
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeEnforce:
+		return "enforce"
+	case ModeDryRun:
+		return "dry-run"
+	case ModeAuditOnly:
+		return "audit-only"
+	default:
+		return "unknown"
+	}
+}
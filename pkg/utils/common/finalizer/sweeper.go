@@ -0,0 +1,78 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package finalizer
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+	"k8s.io/kubernetes/pkg/util/slice"
+)
+
+// ObjectLister returns the current set of objects a Sweeper should inspect,
+// e.g. everything in an informer's Ingress/Service indexer.
+type ObjectLister func() ([]ResourceAdapter, error)
+
+// Sweeper periodically scans objects for module-owned finalizers that have
+// outlived staleThreshold since DeletionTimestamp was set, a common symptom
+// of a controller crashing mid-delete and leaving an orphaned resource
+// behind. Stuck objects are logged and counted so the condition is
+// observable instead of silent.
+type Sweeper struct {
+	lister         ObjectLister
+	keys           []string
+	staleThreshold time.Duration
+	period         time.Duration
+}
+
+// NewSweeper returns a Sweeper that, every period, lists objects via lister
+// and flags any whose DeletionTimestamp is older than staleThreshold while
+// still carrying one of keys.
+func NewSweeper(lister ObjectLister, keys []string, staleThreshold, period time.Duration) *Sweeper {
+	return &Sweeper{lister: lister, keys: keys, staleThreshold: staleThreshold, period: period}
+}
+
+// Run blocks, sweeping every s.period until stopCh is closed.
+func (s *Sweeper) Run(stopCh <-chan struct{}) {
+	wait.Until(s.sweepOnce, s.period, stopCh)
+}
+
+func (s *Sweeper) sweepOnce() {
+	objs, err := s.lister()
+	if err != nil {
+		klog.Errorf("finalizer sweeper: failed to list objects: %v", err)
+		return
+	}
+
+	stuckByKey := map[string]int{}
+	now := time.Now()
+	for _, obj := range objs {
+		meta := obj.GetObjectMeta()
+		if meta.DeletionTimestamp == nil || now.Sub(meta.DeletionTimestamp.Time) < s.staleThreshold {
+			continue
+		}
+		for _, key := range s.keys {
+			if !slice.ContainsString(meta.Finalizers, key, nil) {
+				continue
+			}
+			stuckByKey[key]++
+			klog.Warningf("finalizer sweeper: %s has been deleting for %s but still carries finalizer %q, possible orphan", obj.Key(), now.Sub(meta.DeletionTimestamp.Time), key)
+		}
+	}
+
+	for _, key := range s.keys {
+		stuckObjectsGauge.WithLabelValues(key).Set(float64(stuckByKey[key]))
+	}
+}
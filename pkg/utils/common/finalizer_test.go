@@ -0,0 +1,86 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/api/networking/v1beta1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestIngress(finalizers ...string) *v1beta1.Ingress {
+	return &v1beta1.Ingress{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Namespace:  "ns",
+			Name:       "ing",
+			Finalizers: finalizers,
+		},
+	}
+}
+
+func TestEnsureDeleteFinalizerAfter(t *testing.T) {
+	for _, tc := range []struct {
+		desc           string
+		finalizers     []string
+		wantErr        error
+		wantFinalizers []string
+	}{
+		{
+			desc:           "waits while a prerequisite finalizer is still present",
+			finalizers:     []string{FinalizerKey, NegFinalizerKey},
+			wantErr:        ErrWaitingForFinalizers,
+			wantFinalizers: []string{FinalizerKey, NegFinalizerKey},
+		},
+		{
+			desc:           "removes its own finalizer once prerequisites have cleared",
+			finalizers:     []string{FinalizerKey},
+			wantErr:        nil,
+			wantFinalizers: []string{},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			ing := newTestIngress(tc.finalizers...)
+			client := fakeclientset.NewSimpleClientset(ing)
+			ingClient := client.NetworkingV1beta1().Ingresses(ing.Namespace)
+
+			err := EnsureDeleteFinalizerAfter(ing, ingClient, FinalizerKey, NegFinalizerKey)
+			if err != tc.wantErr {
+				t.Fatalf("EnsureDeleteFinalizerAfter() error = %v, want %v", err, tc.wantErr)
+			}
+
+			got, getErr := ingClient.Get(context.TODO(), ing.Name, meta_v1.GetOptions{})
+			if getErr != nil {
+				t.Fatalf("failed to get ingress: %v", getErr)
+			}
+			if !stringSlicesEqual(got.Finalizers, tc.wantFinalizers) {
+				t.Errorf("ingress finalizers = %v, want %v", got.Finalizers, tc.wantFinalizers)
+			}
+		})
+	}
+}
+
+func stringSlicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}